@@ -0,0 +1,286 @@
+// Client is the DNS client: one-shot queries, zone transfers and dynamic
+// updates, all optionally signed. See the package doc comment in tsig.go
+// for the shared-secret TSIG pattern; Client.TsigProvider extends that to
+// non-shared-secret modes such as GSS-TSIG, and Client.Sig0Key/Sig0Priv
+// sign outgoing messages with SIG(0) instead.
+
+package dns
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// Client is a client to the DNS, used for queries, zone transfers and
+// dynamic updates. The zero value is a usable UDP client with default
+// timeouts; use NewClient for that explicitly.
+type Client struct {
+	Net          string // if "tcp" or "tcp-tls" a TCP query is made, otherwise UDP
+	Attempts     int    // not read by Exchange or XfrReceive, which each make a single attempt
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// TSIG. TsigProvider, if set, signs and verifies every TSIG-carrying
+	// message exchanged by this client, taking precedence over
+	// TsigSecretAlgorithm and TsigSecret. This is how GSS-TSIG (see the
+	// gss subpackage) and other non-shared-secret modes plug in.
+	//
+	// TsigSecretAlgorithm takes precedence over TsigSecret for any key
+	// name it has an entry for, letting callers configure the algorithm
+	// once per key instead of setting it on every outgoing message.
+	TsigProvider        TsigProvider
+	TsigSecretAlgorithm TsigSecretAlgorithm
+	TsigSecret          map[string]string // key name -> base64 secret, signed with the algorithm already set on the message's TSIG
+
+	// SIG(0). If Sig0Priv is set, outgoing messages that already carry a
+	// stub SIG(0) RR (see Msg.SetSig0) are signed with it before sending,
+	// instead of going through the TSIG path above.
+	Sig0Key  *RR_KEY
+	Sig0Priv PrivateKey
+}
+
+// NewClient returns a Client configured for UDP with a single attempt.
+func NewClient() *Client {
+	return &Client{Net: "udp", Attempts: 1}
+}
+
+func (c *Client) dialTimeout() time.Duration {
+	if c.ReadTimeout != 0 {
+		return c.ReadTimeout
+	}
+	return 2 * time.Second
+}
+
+func (c *Client) network() string {
+	if c.Net != "" {
+		return c.Net
+	}
+	return "udp"
+}
+
+// tsigProvider returns the TsigProvider that should sign or verify a
+// message whose TSIG key name is keyname, or nil if the client has no TSIG
+// configured for it. TsigProvider, when set, wins outright; otherwise
+// TsigSecretAlgorithm is consulted, then TsigSecret.
+func (c *Client) tsigProvider(keyname string) TsigProvider {
+	if c.TsigProvider != nil {
+		return c.TsigProvider
+	}
+	if ka, ok := c.TsigSecretAlgorithm[keyname]; ok {
+		return hmacProvider(ka.Secret)
+	}
+	if secret, ok := c.TsigSecret[keyname]; ok {
+		return hmacProvider(secret)
+	}
+	return nil
+}
+
+// sign signs m if it carries a stub TSIG or SIG(0) RR, dispatching to
+// tsigProvider/TsigGenerateProvider or to Sig0Key+Sig0Priv/SigGenerate, and
+// returns the wire-format message to send plus the request MAC (empty for
+// SIG(0) or unsigned messages), mirroring what TsigGenerate returns.
+func (c *Client) sign(m *Msg) ([]byte, string, error) {
+	if m.IsSig0() {
+		if c.Sig0Priv == nil {
+			return nil, "", ErrSecret
+		}
+		mbuf, err := SigGenerate(m, c.Sig0Key, c.Sig0Priv)
+		return mbuf, "", err
+	}
+
+	if !m.IsTsig() {
+		mbuf, ok := m.Pack()
+		if !ok {
+			return nil, "", ErrPack
+		}
+		return mbuf, "", nil
+	}
+
+	rr := m.Extra[len(m.Extra)-1].(*RR_TSIG)
+	if ka, ok := c.TsigSecretAlgorithm[rr.Hdr.Name]; ok {
+		rr.Algorithm = ka.Algorithm
+	}
+	provider := c.tsigProvider(rr.Hdr.Name)
+	if provider == nil {
+		return nil, "", ErrSecret
+	}
+	mbuf, mac, err := TsigGenerateProvider(m, provider, "", false)
+	return mbuf, mac, err
+}
+
+// Exchange sends m to addr and returns the parsed reply, signing m and
+// verifying the reply's TSIG as configured above.
+func (c *Client) Exchange(m *Msg, addr string) (*Msg, error) {
+	mbuf, requestMAC, err := c.sign(m)
+	if err != nil {
+		return nil, err
+	}
+
+	dialNet := c.network()
+	if dialNet == "tcp-tls" {
+		dialNet = "tcp"
+	}
+	conn, err := net.DialTimeout(dialNet, addr, c.dialTimeout())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := writeMsg(conn, c.network(), mbuf); err != nil {
+		return nil, err
+	}
+	rbuf, err := readMsg(conn, c.network())
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(Msg)
+	if ok := r.Unpack(rbuf); !ok {
+		return nil, ErrUnpack
+	}
+	if r.IsTsig() {
+		provider := c.tsigProvider(r.Extra[len(r.Extra)-1].(*RR_TSIG).Hdr.Name)
+		if provider == nil {
+			return nil, ErrSecret
+		}
+		if err := TsigVerifyProvider(rbuf, provider, requestMAC, false); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Envelope is one reply message of a zone transfer: either the RRs it
+// carried, or the error that ended the transfer.
+type Envelope struct {
+	RR    []RR
+	Error error
+}
+
+// XfrReceive sends m, an AXFR or IXFR query, to addr over TCP and streams
+// the RRs of the reply back as Envelopes on the returned channel, which is
+// closed when the transfer ends (successfully or not).
+//
+// If m carries a TSIG, the stream is checked with a TsigVerifier rather
+// than TsigVerify, per RFC 2845 section 4.4: only the first envelope, the
+// last, and every 100th in between need carry their own TSIG, which is how
+// BIND and most other servers behave on a multi-envelope transfer.
+func (c *Client) XfrReceive(m *Msg, addr string) (chan *Envelope, error) {
+	// c.sign removes the stub TSIG from m, so note its key name first.
+	tsigned := m.IsTsig()
+	var keyname string
+	if tsigned {
+		keyname = m.Extra[len(m.Extra)-1].(*RR_TSIG).Hdr.Name
+	}
+	mbuf, requestMAC, err := c.sign(m)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, c.dialTimeout())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writeMsg(conn, "tcp", mbuf); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var verifier *TsigVerifier
+	if tsigned {
+		provider := c.tsigProvider(keyname)
+		if provider == nil {
+			conn.Close()
+			return nil, ErrSecret
+		}
+		verifier = NewTsigVerifier(provider, requestMAC)
+	}
+
+	ch := make(chan *Envelope)
+	go c.receiveXfr(conn, verifier, ch)
+	return ch, nil
+}
+
+// receiveXfr reads envelopes off conn until the transfer's closing SOA, or
+// an error, checking each one against verifier (if the transfer is
+// TSIG-signed) before handing its RRs to ch.
+func (c *Client) receiveXfr(conn net.Conn, verifier *TsigVerifier, ch chan *Envelope) {
+	defer conn.Close()
+	defer close(ch)
+
+	soas := 0
+	for {
+		envelope, err := readMsg(conn, "tcp")
+		if err != nil {
+			ch <- &Envelope{Error: err}
+			return
+		}
+		if verifier != nil {
+			if err := verifier.AddEnvelope(envelope); err != nil {
+				ch <- &Envelope{Error: err}
+				return
+			}
+		}
+
+		r := new(Msg)
+		if ok := r.Unpack(envelope); !ok {
+			ch <- &Envelope{Error: ErrUnpack}
+			return
+		}
+		ch <- &Envelope{RR: r.Answer}
+
+		for _, rr := range r.Answer {
+			if rr.Header().Rrtype == TypeSOA {
+				soas++
+			}
+		}
+		if soas >= 2 {
+			if verifier != nil {
+				if err := verifier.Finish(); err != nil {
+					ch <- &Envelope{Error: err}
+				}
+			}
+			return
+		}
+	}
+}
+
+// writeMsg writes mbuf to conn, prefixed with a 2-byte big-endian length
+// when network is a stream ("tcp"/"tcp-tls"), per RFC 1035 section 4.2.2.
+func writeMsg(conn net.Conn, network string, mbuf []byte) (int, error) {
+	if network == "tcp" || network == "tcp-tls" {
+		l := []byte{byte(len(mbuf) >> 8), byte(len(mbuf))}
+		if _, err := conn.Write(l); err != nil {
+			return 0, err
+		}
+	}
+	return conn.Write(mbuf)
+}
+
+// readMsg reads one message from conn, stripping the 2-byte length prefix
+// stream transports use. Unlike UDP, a TCP envelope's length is unbounded
+// by DefaultMsgSize - AXFR/IXFR routinely exceed it - so the buffer is
+// sized to the prefix rather than fixed.
+func readMsg(conn net.Conn, network string) ([]byte, error) {
+	if network != "tcp" && network != "tcp-tls" {
+		buf := make([]byte, DefaultMsgSize)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+
+	var lbuf [2]byte
+	if _, err := io.ReadFull(conn, lbuf[:]); err != nil {
+		return nil, err
+	}
+	l := int(lbuf[0])<<8 | int(lbuf[1])
+
+	buf := make([]byte, l)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,119 @@
+// Package gss implements GSS-TSIG (RFC 3645), the GSS-API security mechanism
+// for TSIG. It lets a dns.Client authenticate dynamic updates and zone
+// transfers against a Kerberos/SPNEGO-speaking nameserver, most commonly an
+// Active Directory-integrated DNS server, without provisioning a shared
+// secret out of band.
+//
+// Basic use pattern, given a Kerberos config krb5cfg and credentials creds,
+// negotiating a context against the AD server "dc1.example.com" and using
+// it to sign an update:
+//
+//	client := gss.NewClient(&dns.Client{}, krb5cfg, creds)
+//	keyname, ctx, err := client.NegotiateContext("dc1.example.com:53", "client.example.com.", "DNS/dc1.example.com")
+//	defer client.Close(ctx)
+//	client.TsigProvider = ctx
+//
+//	m := new(dns.Msg)
+//	m.SetUpdate("example.com.")
+//	m.SetTsig(keyname, gss.Algorithm, 300, time.Now().Unix())
+//	in, err := client.Exchange(m, "dc1.example.com:53")
+package gss
+
+import (
+	"encoding/hex"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+
+	"github.com/vermuz/dns"
+)
+
+// Algorithm is the TSIG algorithm name GSS-TSIG messages are signed under,
+// RFC 3645 section 2.
+const Algorithm = "gss-tsig."
+
+// Client wraps a *dns.Client and the Kerberos client needed to negotiate
+// GSS-API security contexts for it.
+type Client struct {
+	*dns.Client
+	krb5 *client.Client
+}
+
+// NewClient returns a Client that uses dnsClient to talk to the nameserver
+// and krb5cfg/creds to authenticate to Kerberos. If dnsClient is nil a
+// default *dns.Client is used.
+func NewClient(dnsClient *dns.Client, krb5cfg *config.Config, creds *credentials.Credentials) *Client {
+	if dnsClient == nil {
+		dnsClient = new(dns.Client)
+	}
+	return &Client{Client: dnsClient, krb5: client.NewWithCredentials(creds, krb5cfg)}
+}
+
+// context is the established GSS-API security context for one TSIG key
+// name. It implements dns.TsigProvider by wrapping the context's GetMIC
+// (Generate) and VerifyMIC (Verify) operations.
+type context struct {
+	sctx gssapi.SecurityContext
+}
+
+// securityContext drives the client side of the multi-round TKEY exchange:
+// Init wraps gokrb5's InitSecContext, producing the next SPNEGO token to
+// send and reporting whether the context is now established.
+type securityContext struct {
+	krb5 *client.Client
+	spn  string
+	sctx gssapi.SecurityContext
+}
+
+func (s *securityContext) Init(input []byte) (output []byte, done bool, err error) {
+	tok, established, ctx, err := s.krb5.InitSecContext(s.spn, input)
+	if err != nil {
+		return nil, false, err
+	}
+	s.sctx = ctx
+	return tok, established, nil
+}
+
+// NegotiateContext establishes a GSS-API security context against the
+// nameserver at addr under the TKEY key name keyname, authenticating to the
+// service principal name spn (e.g. "DNS/dc1.example.com"), using
+// dns.TkeyNegotiate to drive the
+// TKEY exchange. It returns the TSIG key name the caller should use with
+// SetTsig and the resulting dns.TsigProvider, which must be supplied as
+// Client.TsigProvider on subsequent requests.
+func (c *Client) NegotiateContext(addr, keyname, spn string) (string, dns.TsigProvider, error) {
+	sc := &securityContext{krb5: c.krb5, spn: spn}
+	name, err := dns.TkeyNegotiate(c.Client, sc, keyname, addr)
+	if err != nil {
+		return "", nil, err
+	}
+	return name, &context{sctx: sc.sctx}, nil
+}
+
+func (c *context) Generate(msg []byte, t *dns.RR_TSIG) ([]byte, error) {
+	tok, err := c.sctx.GetMIC(msg)
+	if err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+func (c *context) Verify(msg []byte, t *dns.RR_TSIG) error {
+	mac, err := hex.DecodeString(t.MAC)
+	if err != nil {
+		return err
+	}
+	return c.sctx.VerifyMIC(msg, mac)
+}
+
+// Close tears down the Kerberos security context, as required by GSS-API
+// once a caller is done signing or verifying with it.
+func (c *Client) Close(ctx dns.TsigProvider) error {
+	gctx, ok := ctx.(*context)
+	if !ok {
+		return nil
+	}
+	return gctx.sctx.DeleteSecContext()
+}
@@ -0,0 +1,409 @@
+// SIG(0)
+//
+// SIG(0) (RFC 2931) signs an entire DNS message with a public-key algorithm,
+// using the same DNSKEY/RRSIG machinery as zone signing. Unlike TSIG it
+// needs no shared secret, which matters for dynamic update deployments
+// where distributing a symmetric key to every updater is impractical.
+//
+// Basic use pattern signing an update with a KEY RR "update.example.com."
+// and its matching private key:
+//
+//	m := new(Msg)
+//	m.SetUpdate("example.com.")
+//	m.SetSig0(k)
+//	mbuf, err := SigGenerate(m, k, priv)
+//
+// On the server side, ListenAndServeSig0 looks up the signer's KEY RR by
+// owner name (via lookupKey) and validates the request before invoking the
+// handler.
+package dns
+
+import (
+	"encoding/base64"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RR_SIG0 is the transaction SIG(0) record, RFC 2931 section 3. It has the
+// same rdata layout as a zone RRSIG, but TypeCovered is 0 and it signs the
+// whole message rather than a single RRset.
+type RR_SIG0 struct {
+	Hdr         RR_Header
+	TypeCovered uint16
+	Algorithm   uint8
+	Labels      uint8
+	OrigTtl     uint32
+	Expiration  uint32
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  string `dns:"domain-name"`
+	Signature   string `dns:"base64"`
+}
+
+func (rr *RR_SIG0) Header() *RR_Header {
+	return &rr.Hdr
+}
+
+func (rr *RR_SIG0) String() string {
+	s := "\n;; SIG0 PSEUDOSECTION:\n"
+	s += rr.Hdr.String() +
+		" " + strconv.Itoa(int(rr.TypeCovered)) +
+		" " + strconv.Itoa(int(rr.Algorithm)) +
+		" " + strconv.Itoa(int(rr.Labels)) +
+		" " + strconv.Itoa(int(rr.OrigTtl)) +
+		" " + tsigTimeToDate(uint64(rr.Expiration)) +
+		" " + tsigTimeToDate(uint64(rr.Inception)) +
+		" " + strconv.Itoa(int(rr.KeyTag)) +
+		" " + rr.SignerName +
+		" " + rr.Signature
+	return s
+}
+
+func (rr *RR_SIG0) Len() int {
+	return rr.Hdr.Len() + 2 + 1 + 1 + 4 + 4 + 4 + 2 + len(rr.SignerName) + 1 + base64.StdEncoding.DecodedLen(len(rr.Signature))
+}
+
+// Registers RR_SIG0 with the rest of the package so unpackRR can build one
+// off the wire. TypeSIG is 24, the legacy SIG record RFC 2931 reuses for
+// SIG(0); it is distinct from TypeRRSIG (46), the zone-signing RRSIG used
+// by DNSSEC, and both are defined in types.go, not here.
+func init() {
+	TypeToRR[TypeSIG] = func() RR { return new(RR_SIG0) }
+}
+
+// SetSig0 appends a "stub" SIG(0) RR to m, to be filled in by SigGenerate.
+// Its signer name is the owner name of k, the KEY RR the receiver should
+// look up to verify the message.
+func (m *Msg) SetSig0(k *RR_KEY) *Msg {
+	sig := new(RR_SIG0)
+	sig.Hdr = RR_Header{Name: k.Hdr.Name, Rrtype: TypeSIG, Class: ClassANY, Ttl: 0}
+	sig.Algorithm = k.Algorithm
+	sig.SignerName = strings.ToLower(k.Hdr.Name)
+	sig.KeyTag = k.KeyTag()
+	sig.Inception = uint32(time.Now().Unix())
+	sig.Expiration = sig.Inception + 300
+	m.Extra = append(m.Extra, sig)
+	return m
+}
+
+// IsSig0 reports whether m's last Extra record is a stub or signed SIG(0)
+// RR, mirroring Msg.IsTsig.
+func (m *Msg) IsSig0() bool {
+	if len(m.Extra) == 0 {
+		return false
+	}
+	_, ok := m.Extra[len(m.Extra)-1].(*RR_SIG0)
+	return ok
+}
+
+// SigGenerate signs m with priv, the private half of the keypair described
+// by k, and returns the signed wire-format message. m must already carry a
+// stub SIG(0) RR as its last Extra record (see SetSig0); the owner name,
+// algorithm and key tag are taken from it, matching the TsigGenerate
+// calling convention.
+func SigGenerate(m *Msg, k *RR_KEY, priv PrivateKey) ([]byte, error) {
+	if len(m.Extra) == 0 {
+		return nil, ErrNoSig
+	}
+	sig, ok := m.Extra[len(m.Extra)-1].(*RR_SIG0)
+	if !ok {
+		return nil, ErrNoSig
+	}
+	m.Extra = m.Extra[:len(m.Extra)-1]
+
+	mbuf, ok := m.Pack()
+	if !ok {
+		return nil, ErrPack
+	}
+
+	buf, err := sigBuffer(mbuf, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := sign(priv, buf, k.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	sig.Signature = signature
+
+	sbuf := make([]byte, sig.Len())
+	off, ok := packRR(sig, sbuf, 0, nil, false)
+	if !ok {
+		return nil, ErrPack
+	}
+	mbuf = append(mbuf, sbuf[:off]...)
+	RawSetExtraLen(mbuf, uint16(len(m.Extra)+1))
+	return mbuf, nil
+}
+
+// SigVerify verifies the SIG(0) on msg against k, the signer's public KEY
+// RR. It mirrors TsigVerify: if the signature does not validate, or has
+// expired, err is non-nil.
+func SigVerify(msg []byte, k *RR_KEY) error {
+	stripped, sig, err := stripSig0(msg)
+	if err != nil {
+		return err
+	}
+
+	now := uint32(time.Now().Unix())
+	if sig.Expiration != 0 && now > sig.Expiration {
+		return ErrTime
+	}
+	if sig.Inception != 0 && now < sig.Inception {
+		return ErrTime
+	}
+
+	signature := sig.Signature
+	sig.Signature = ""
+	buf, err := sigBuffer(stripped, sig)
+	sig.Signature = signature
+	if err != nil {
+		return err
+	}
+
+	return verify(k, buf, signature, sig.Algorithm)
+}
+
+// sigBuffer builds the data covered by a SIG(0) signature: the SIG RDATA
+// up to but excluding the Signature field, followed by the message with
+// the SIG RR itself removed. RFC 2931 section 3.1.
+func sigBuffer(msgbuf []byte, sig *RR_SIG0) ([]byte, error) {
+	s := new(sig0WireFmt)
+	s.TypeCovered = sig.TypeCovered
+	s.Algorithm = sig.Algorithm
+	s.Labels = sig.Labels
+	s.OrigTtl = sig.OrigTtl
+	s.Expiration = sig.Expiration
+	s.Inception = sig.Inception
+	s.KeyTag = sig.KeyTag
+	s.SignerName = strings.ToLower(sig.SignerName)
+
+	sbuf := make([]byte, DefaultMsgSize)
+	n, ok := packStruct(s, sbuf, 0)
+	if !ok {
+		return nil, ErrPack
+	}
+	sbuf = sbuf[:n]
+
+	return append(sbuf, msgbuf...), nil
+}
+
+// sig0WireFmt is the SIG RDATA up to the Signature field, RFC 2931
+// section 3.1.
+type sig0WireFmt struct {
+	TypeCovered uint16
+	Algorithm   uint8
+	Labels      uint8
+	OrigTtl     uint32
+	Expiration  uint32
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  string `dns:"domain-name"`
+}
+
+// stripSig0 removes the trailing SIG(0) RR from msg, returning the
+// original wire bytes up to it unchanged apart from ARCOUNT, which is
+// decremented in place in msg. It is copied from stripTsig:
+// slicing the raw message rather than unpacking and repacking it matters
+// here, because repacking can change name compression, case or RR
+// ordering and so change the bytes a signature was actually computed over.
+func stripSig0(msg []byte) ([]byte, *RR_SIG0, error) {
+	var dh Header
+	dns := new(Msg)
+	sig := new(RR_SIG0)
+	off := 0
+	sigoff := 0
+	var ok bool
+	if off, ok = unpackStruct(&dh, msg, off); !ok {
+		return nil, nil, ErrUnpack
+	}
+	if dh.Arcount == 0 {
+		return nil, nil, ErrNoSig
+	}
+	if int(dh.Bits&0xF) == RcodeNotAuth {
+		return nil, nil, ErrAuth
+	}
+
+	dns.Question = make([]Question, dh.Qdcount)
+	dns.Answer = make([]RR, dh.Ancount)
+	dns.Ns = make([]RR, dh.Nscount)
+	dns.Extra = make([]RR, dh.Arcount)
+
+	for i := 0; i < len(dns.Question); i++ {
+		off, ok = unpackStruct(&dns.Question[i], msg, off)
+	}
+	for i := 0; i < len(dns.Answer); i++ {
+		dns.Answer[i], off, ok = unpackRR(msg, off)
+	}
+	for i := 0; i < len(dns.Ns); i++ {
+		dns.Ns[i], off, ok = unpackRR(msg, off)
+	}
+	for i := 0; i < len(dns.Extra); i++ {
+		sigoff = off
+		dns.Extra[i], off, ok = unpackRR(msg, off)
+		if dns.Extra[i].Header().Rrtype == TypeSIG {
+			sig = dns.Extra[i].(*RR_SIG0)
+			// Adjust Arcount.
+			arcount, _ := unpackUint16(msg, 10)
+			msg[10], msg[11] = packUint16(arcount - 1)
+			break
+		}
+	}
+	if !ok {
+		return nil, nil, ErrUnpack
+	}
+	if sig == nil {
+		return nil, nil, ErrNoSig
+	}
+	return msg[:sigoff], sig, nil
+}
+
+// LookupKeyFunc resolves the KEY RR that a SIG(0)-signed message claims to
+// be signed by, keyed on the owner name carried in its SIG RR. It is
+// typically backed by a zone lookup or a small static map of trusted peers.
+type LookupKeyFunc func(signerName string) (*RR_KEY, error)
+
+// ListenAndServeSig0 serves DNS on addr/network, and for every inbound
+// request looks up the signer's KEY RR with lookupKey and verifies the
+// SIG(0) before handing the request to handler. Requests with no SIG(0)
+// are handed to handler unchecked; requests whose SIG(0) key cannot be
+// looked up, or whose SIG(0) fails verification, are rejected with
+// RcodeNotAuth rather than reaching handler.
+//
+// Unlike ListenAndServeTsig, this does not go through the generic Server:
+// SigVerify must see the exact bytes the signer hashed (see the stripSig0
+// doc comment), and those bytes no longer exist once a Handler is only
+// handed a parsed *Msg. So ListenAndServeSig0 owns the read loop itself and
+// verifies before Unpack.
+func ListenAndServeSig0(addr, network string, handler Handler, lookupKey LookupKeyFunc) error {
+	if network == "tcp" || network == "tcp-tls" {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return err
+			}
+			go serveSig0Stream(conn, network, handler, lookupKey)
+		}
+	}
+
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	buf := make([]byte, DefaultMsgSize)
+	for {
+		n, raddr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		msg := append([]byte(nil), buf[:n]...)
+		go serveSig0Msg(&sig0ResponseWriter{pconn: conn, addr: raddr}, msg, handler, lookupKey)
+	}
+}
+
+// serveSig0Stream handles one TCP connection, reading and replying to
+// length-prefixed requests until the peer closes it or a read fails.
+func serveSig0Stream(conn net.Conn, network string, handler Handler, lookupKey LookupKeyFunc) {
+	defer conn.Close()
+	w := &sig0ResponseWriter{conn: conn, network: network}
+	for {
+		msg, err := readMsg(conn, network)
+		if err != nil {
+			return
+		}
+		serveSig0Msg(w, msg, handler, lookupKey)
+	}
+}
+
+// serveSig0Msg verifies the SIG(0) on the raw request msg, if any, against
+// lookupKey before unpacking and dispatching to handler.
+func serveSig0Msg(w ResponseWriter, msg []byte, handler Handler, lookupKey LookupKeyFunc) {
+	r := new(Msg)
+	if ok := r.Unpack(msg); !ok {
+		return
+	}
+	if !r.IsSig0() {
+		handler.ServeDNS(w, r)
+		return
+	}
+
+	sig := r.Extra[len(r.Extra)-1].(*RR_SIG0)
+	k, err := lookupKey(sig.SignerName)
+	if err != nil {
+		m := new(Msg)
+		m.SetRcode(r, RcodeNotAuth)
+		w.WriteMsg(m)
+		return
+	}
+	if err := SigVerify(msg, k); err != nil {
+		m := new(Msg)
+		m.SetRcode(r, RcodeNotAuth)
+		w.WriteMsg(m)
+		return
+	}
+
+	handler.ServeDNS(w, r)
+}
+
+// sig0ResponseWriter implements ResponseWriter over either a UDP
+// PacketConn+peer address or a single TCP connection, whichever
+// ListenAndServeSig0 is using.
+type sig0ResponseWriter struct {
+	conn    net.Conn       // set for "tcp"/"tcp-tls"
+	pconn   net.PacketConn // set for "udp"
+	addr    net.Addr       // peer address, set for "udp"
+	network string
+}
+
+func (w *sig0ResponseWriter) LocalAddr() net.Addr {
+	if w.conn != nil {
+		return w.conn.LocalAddr()
+	}
+	return w.pconn.LocalAddr()
+}
+
+func (w *sig0ResponseWriter) RemoteAddr() net.Addr {
+	if w.conn != nil {
+		return w.conn.RemoteAddr()
+	}
+	return w.addr
+}
+
+func (w *sig0ResponseWriter) Write(b []byte) (int, error) {
+	if w.conn != nil {
+		return writeMsg(w.conn, w.network, b)
+	}
+	return w.pconn.WriteTo(b, w.addr)
+}
+
+func (w *sig0ResponseWriter) WriteMsg(m *Msg) error {
+	mbuf, ok := m.Pack()
+	if !ok {
+		return ErrPack
+	}
+	_, err := w.Write(mbuf)
+	return err
+}
+
+func (w *sig0ResponseWriter) Close() error {
+	if w.conn != nil {
+		return w.conn.Close()
+	}
+	return nil
+}
+
+// SIG(0) has no TSIG to report, or timer semantics to toggle.
+func (w *sig0ResponseWriter) TsigStatus() error   { return nil }
+func (w *sig0ResponseWriter) TsigTimersOnly(bool) {}
+
+// Hijack is not supported over the plain listener ListenAndServeSig0 uses.
+func (w *sig0ResponseWriter) Hijack() {}
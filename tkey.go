@@ -0,0 +1,151 @@
+// TKEY (RFC 2930) is used to establish and delete the shared secret used by
+// TSIG. It is most commonly seen carrying a GSS-API security context token,
+// which is what GSS-TSIG (RFC 3645) authenticates dynamic updates with
+// against Active Directory-integrated DNS.
+
+package dns
+
+import (
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// TKEY mode field values, RFC 2930 section 2.5.
+const (
+	TkeyModeServerAssignment = 1
+	TkeyModeDiffieHellman    = 2
+	TkeyModeGSSAPI           = 3
+	TkeyModeResolverAssigned = 4
+	TkeyModeDelete           = 5
+)
+
+// RR_TKEY is the RFC 2930 TKEY resource record.
+type RR_TKEY struct {
+	Hdr        RR_Header
+	Algorithm  string `dns:"domain-name"`
+	Inception  uint32
+	Expiration uint32
+	Mode       uint16
+	Error      uint16
+	KeySize    uint16
+	Key        string `dns:"size-hex"`
+	OtherLen   uint16
+	OtherData  string `dns:"size-hex"`
+}
+
+func (rr *RR_TKEY) Header() *RR_Header {
+	return &rr.Hdr
+}
+
+// TKEY has no official presentation format either, mirror TSIG's.
+func (rr *RR_TKEY) String() string {
+	s := "\n;; TKEY PSEUDOSECTION:\n"
+	s += rr.Hdr.String() +
+		" " + rr.Algorithm +
+		" " + strconv.Itoa(int(rr.Inception)) +
+		" " + strconv.Itoa(int(rr.Expiration)) +
+		" " + strconv.Itoa(int(rr.Mode)) +
+		" " + strconv.Itoa(int(rr.Error)) +
+		" " + strconv.Itoa(int(rr.KeySize)) +
+		" " + rr.Key +
+		" " + strconv.Itoa(int(rr.OtherLen)) +
+		" " + rr.OtherData
+	return s
+}
+
+func (rr *RR_TKEY) Len() int {
+	return rr.Hdr.Len() + len(rr.Algorithm) + 1 + 4 + 4 + 2 + 2 +
+		2 + len(rr.Key)/2 + 2 + len(rr.OtherData)/2
+}
+
+// Registers RR_TKEY with the rest of the package so unpackRR can build one
+// off the wire. TypeTKEY is 249 per RFC 2930 section 2, distinct from
+// TypeTSIG (250); both are defined in types.go, not here.
+func init() {
+	TypeToRR[TypeTKEY] = func() RR { return new(RR_TKEY) }
+}
+
+// TkeyRoundTripper sends a single TKEY query built by TkeyNegotiate and
+// returns the server's reply. Client satisfies this with its normal
+// Exchange method; it is its own interface so the GSS-TSIG negotiation
+// below does not have to depend on the rest of the Client API.
+type TkeyRoundTripper interface {
+	Exchange(m *Msg, addr string) (*Msg, error)
+}
+
+// TkeyNegotiate runs the GSS-API TKEY exchange described in RFC 2930
+// section 4.1/RFC 3645 section 3: it repeatedly calls provider.Init to
+// produce the next outbound security token (nil input on the first round),
+// wraps it in a TKEY query with Mode TkeyModeGSSAPI under keyname, sends it
+// to addr via rt, and feeds the responding TKEY's Key back into
+// provider.Init until the provider reports the context is established.
+// It returns the key name the server should be asked to use as the TSIG
+// owner name for subsequent requests, which is keyname unless the server
+// rewrote it in the final reply.
+func TkeyNegotiate(rt TkeyRoundTripper, provider GSSProvider, keyname, addr string) (string, error) {
+	var input []byte
+	for {
+		output, done, err := provider.Init(input)
+		if err != nil {
+			return "", err
+		}
+
+		m := new(Msg)
+		m.SetTkeyGSS(keyname, output)
+		r, err := rt.Exchange(m, addr)
+		if err != nil {
+			return "", err
+		}
+		if len(r.Answer) == 0 {
+			return "", ErrNoSig
+		}
+		tkey, ok := r.Answer[0].(*RR_TKEY)
+		if !ok {
+			return "", ErrNoSig
+		}
+		if tkey.Error != 0 {
+			return "", ErrKeyAlg
+		}
+
+		keyname = tkey.Hdr.Name
+		if done {
+			return keyname, nil
+		}
+		input, err = hex.DecodeString(tkey.Key)
+		if err != nil {
+			return "", ErrUnpack
+		}
+	}
+}
+
+// GSSProvider drives the client side of a GSS-API negotiation for
+// TkeyNegotiate. Init is called once per round: input is the token received
+// from the server (nil on the first call), and it returns the token to send
+// back and whether the security context is now fully established. The gss
+// subpackage implements this on top of a Kerberos/SPNEGO mechanism.
+type GSSProvider interface {
+	Init(input []byte) (output []byte, done bool, err error)
+}
+
+// SetTkeyGSS creates a TKEY query requesting establishment of a GSS-API
+// security context under owner name keyname, carrying token as the
+// RFC 2930 "key data".
+func (m *Msg) SetTkeyGSS(keyname string, token []byte) {
+	m.SetQuestion(keyname, TypeTKEY)
+	key := hex.EncodeToString(token)
+	tkey := &RR_TKEY{
+		Hdr:        RR_Header{Name: keyname, Rrtype: TypeTKEY, Class: ClassANY, Ttl: 0},
+		Algorithm:  gssTsigAlgorithm,
+		Mode:       TkeyModeGSSAPI,
+		KeySize:    uint16(len(key) / 2),
+		Key:        key,
+		Inception:  uint32(time.Now().Unix()),
+		Expiration: uint32(time.Now().Unix()) + 3600,
+	}
+	m.Extra = []RR{tkey}
+}
+
+// gssTsigAlgorithm is the algorithm name used on the wire for GSS-TSIG,
+// RFC 3645 section 2.
+const gssTsigAlgorithm = "gss-tsig."
@@ -54,7 +54,9 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
+	"errors"
 	"hash"
 	"io"
 	"strconv"
@@ -62,13 +64,34 @@ import (
 	"time"
 )
 
+// ErrShortMAC is returned by TsigVerify when the MAC on a TSIG is
+// truncated below the RFC 4635 section 3.1 minimum for its algorithm.
+var ErrShortMAC = errors.New("dns: TSIG MAC too short")
+
 // HMAC hashing codes. These are transmitted as domain names.
 const (
 	HmacMD5    = "hmac-md5.sig-alg.reg.int."
 	HmacSHA1   = "hmac-sha1."
+	HmacSHA224 = "hmac-sha224."
 	HmacSHA256 = "hmac-sha256."
+	HmacSHA384 = "hmac-sha384."
+	HmacSHA512 = "hmac-sha512."
 )
 
+// TsigSecretAlgorithm maps a TSIG key name to the secret and algorithm that
+// should be used for it, so that callers don't have to pass the algorithm
+// to SetTsig on every outgoing message. It is meant to be set on
+// Client.TsigSecretAlgorithm or Server.TsigSecretAlgorithm; when present it
+// takes precedence over the plain TsigSecret map for keys it contains.
+type TsigSecretAlgorithm map[string]TsigKeyAlgorithm
+
+// TsigKeyAlgorithm is one entry of a TsigSecretAlgorithm map: the base64
+// secret for a key name and the HMAC algorithm it was provisioned with.
+type TsigKeyAlgorithm struct {
+	Algorithm string
+	Secret    string
+}
+
 // RFC 2845.
 type RR_TSIG struct {
 	Hdr        RR_Header
@@ -139,23 +162,131 @@ type timerWireFmt struct {
 	Fudge      uint16
 }
 
+// TsigProvider computes and checks the MAC that authenticates a message,
+// letting the TSIG key material be something other than a local shared
+// secret. msg is the wire-format buffer described in RFC 2845 section 3.4
+// (the message being signed, with the timers or full TSIG variables already
+// appended by tsigBuffer); tsig is the stub RR carrying the key name,
+// algorithm and, for Verify, the MAC to check.
+//
+// The gss subpackage implements this interface on top of a negotiated
+// GSS-API security context, for use as Client.TsigProvider when talking to
+// a GSS-TSIG (RFC 3645) server such as Active Directory-integrated DNS.
+type TsigProvider interface {
+	Generate(msg []byte, tsig *RR_TSIG) ([]byte, error)
+	Verify(msg []byte, tsig *RR_TSIG) error
+}
+
+// hmacProvider is the default TsigProvider, backing TsigGenerate/TsigVerify
+// with the shared-secret HMAC algorithms this package has always supported.
+type hmacProvider string
+
+func (h hmacProvider) Generate(msg []byte, t *RR_TSIG) ([]byte, error) {
+	rawsecret, err := packBase64([]byte(string(h)))
+	if err != nil {
+		return nil, err
+	}
+	m, err := hmacHash(t.Algorithm, rawsecret)
+	if err != nil {
+		return nil, err
+	}
+	io.WriteString(m, string(msg))
+	return m.Sum(nil), nil
+}
+
+func (h hmacProvider) Verify(msg []byte, t *RR_TSIG) error {
+	// RFC 4635 section 3.1: reject MACs truncated below half the
+	// algorithm's output size, and never below 10 octets, before even
+	// hashing anything.
+	min, full := tsigMACMinLen(t.Algorithm)
+	if int(t.MACSize) < min {
+		return ErrShortMAC
+	}
+
+	mac, err := h.Generate(msg, t)
+	if err != nil {
+		return err
+	}
+	odata, err := hex.DecodeString(t.MAC)
+	if err != nil {
+		return err
+	}
+	if len(odata) > full {
+		return ErrSig
+	}
+	if !hmac.Equal(mac[:len(odata)], odata) {
+		return ErrSig
+	}
+	return nil
+}
+
+// hmacHash returns the keyed hash for algorithm, or ErrKeyAlg if algorithm
+// is not one of the supported Hmac* names.
+func hmacHash(algorithm string, rawsecret []byte) (hash.Hash, error) {
+	switch algorithm {
+	case HmacMD5:
+		return hmac.New(md5.New, rawsecret), nil
+	case HmacSHA1:
+		return hmac.New(sha1.New, rawsecret), nil
+	case HmacSHA224:
+		return hmac.New(sha256.New224, rawsecret), nil
+	case HmacSHA256:
+		return hmac.New(sha256.New, rawsecret), nil
+	case HmacSHA384:
+		return hmac.New(sha512.New384, rawsecret), nil
+	case HmacSHA512:
+		return hmac.New(sha512.New, rawsecret), nil
+	}
+	return nil, ErrKeyAlg
+}
+
+// tsigMACMinLen returns the RFC 4635 section 3.1 minimum accepted MAC
+// length and the algorithm's full output length, both in octets, for
+// algorithm. Unknown algorithms get the RFC's blanket 10-octet floor.
+func tsigMACMinLen(algorithm string) (min, full int) {
+	switch algorithm {
+	case HmacMD5:
+		full = md5.Size
+	case HmacSHA1:
+		full = sha1.Size
+	case HmacSHA224:
+		full = sha256.Size224
+	case HmacSHA256:
+		full = sha256.Size
+	case HmacSHA384:
+		full = sha512.Size384
+	case HmacSHA512:
+		full = sha512.Size
+	default:
+		return 10, 0
+	}
+	min = full / 2
+	if min < 10 {
+		min = 10
+	}
+	return min, full
+}
+
 // TsigGenerate fills out the TSIG record attached to the message.
 // The message should contain
-// a "stub" TSIG RR with the algorithm, key name (owner name of the RR), 
-// time fudge (defaults to 300 seconds) and the current time       
-// The TSIG MAC is saved in that Tsig RR.                          
+// a "stub" TSIG RR with the algorithm, key name (owner name of the RR),
+// time fudge (defaults to 300 seconds) and the current time
+// The TSIG MAC is saved in that Tsig RR.
 // When TsigGenerate is called for the first time requestMAC is set to the empty string and
-// timersOnly is false.                                            
-// If something goes wrong an error is returned, otherwise it is nil. 
+// timersOnly is false.
+// If something goes wrong an error is returned, otherwise it is nil.
 func TsigGenerate(m *Msg, secret, requestMAC string, timersOnly bool) ([]byte, string, error) {
+	return TsigGenerateProvider(m, hmacProvider(secret), requestMAC, timersOnly)
+}
+
+// TsigGenerateProvider is like TsigGenerate, but signs the message with the
+// given TsigProvider instead of a local shared secret. Client dispatches to
+// this when Client.TsigProvider is set, which is how GSS-TSIG (RFC 3645)
+// and other non-shared-secret modes plug in.
+func TsigGenerateProvider(m *Msg, provider TsigProvider, requestMAC string, timersOnly bool) ([]byte, string, error) {
 	if !m.IsTsig() {
 		panic("TSIG not last RR in additional")
 	}
-	// If we barf here, the caller is to blame
-	rawsecret, err := packBase64([]byte(secret))
-	if err != nil {
-		return nil, "", err
-	}
 
 	rr := m.Extra[len(m.Extra)-1].(*RR_TSIG)
 	m.Extra = m.Extra[0 : len(m.Extra)-1] // kill the TSIG from the msg
@@ -165,20 +296,13 @@ func TsigGenerate(m *Msg, secret, requestMAC string, timersOnly bool) ([]byte, s
 	}
 	buf := tsigBuffer(mbuf, rr, requestMAC, timersOnly)
 
-	t := new(RR_TSIG)
-	var h hash.Hash
-	switch rr.Algorithm {
-	case HmacMD5:
-		h = hmac.New(md5.New, []byte(rawsecret))
-	case HmacSHA1:
-		h = hmac.New(sha1.New, []byte(rawsecret))
-	case HmacSHA256:
-		h = hmac.New(sha256.New, []byte(rawsecret))
-	default:
-		return nil, "", ErrKeyAlg
+	mac, err := provider.Generate(buf, rr)
+	if err != nil {
+		return nil, "", err
 	}
-	io.WriteString(h, string(buf))
-	t.MAC = hex.EncodeToString(h.Sum(nil))
+
+	t := new(RR_TSIG)
+	t.MAC = hex.EncodeToString(mac)
 	t.MACSize = uint16(len(t.MAC) / 2) // Size is half!
 
 	t.Hdr = RR_Header{Name: rr.Hdr.Name, Rrtype: TypeTSIG, Class: ClassANY, Ttl: 0}
@@ -198,15 +322,18 @@ func TsigGenerate(m *Msg, secret, requestMAC string, timersOnly bool) ([]byte, s
 	return mbuf, t.MAC, nil
 }
 
-// TsigVerify verifies the TSIG on a message. 
+// TsigVerify verifies the TSIG on a message.
 // If the signature does not validate err contains the
 // error, otherwise it is nil.
 func TsigVerify(msg []byte, secret, requestMAC string, timersOnly bool) error {
-	rawsecret, err := packBase64([]byte(secret))
-	if err != nil {
-		return err
-	}
-	// Srtip the TSIG from the incoming msg
+	return TsigVerifyProvider(msg, hmacProvider(secret), requestMAC, timersOnly)
+}
+
+// TsigVerifyProvider is like TsigVerify, but checks the MAC with the given
+// TsigProvider instead of a local shared secret. Server dispatches to this
+// when Server.TsigProvider is set.
+func TsigVerifyProvider(msg []byte, provider TsigProvider, requestMAC string, timersOnly bool) error {
+	// Strip the TSIG from the incoming msg
 	stripped, tsig, err := stripTsig(msg)
 	if err != nil {
 		return err
@@ -219,22 +346,7 @@ func TsigVerify(msg []byte, secret, requestMAC string, timersOnly bool) error {
 		return ErrTime
 	}
 
-	var h hash.Hash
-	switch tsig.Algorithm {
-	case HmacMD5:
-		h = hmac.New(md5.New, []byte(rawsecret))
-	case HmacSHA1:
-		h = hmac.New(sha1.New, []byte(rawsecret))
-	case HmacSHA256:
-		h = hmac.New(sha256.New, []byte(rawsecret))
-	default:
-		return ErrKeyAlg
-	}
-	io.WriteString(h, string(buf))
-	if strings.ToUpper(hex.EncodeToString(h.Sum(nil))) != strings.ToUpper(tsig.MAC) {
-		return ErrSig
-	}
-	return nil
+	return provider.Verify(buf, tsig)
 }
 
 // Create a wiredata buffer for the MAC calculation.
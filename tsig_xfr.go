@@ -0,0 +1,106 @@
+// Multi-message TSIG, RFC 2845 section 4.4: a zone transfer may sign only
+// every Nth envelope rather than every one, with the unsigned envelopes in
+// between folded into the MAC that covers the next signed envelope.
+// TsigGenerate/TsigVerify only know how to check a single, fully-signed
+// message, so XfrReceive uses TsigVerifier to check an AXFR/IXFR stream
+// instead.
+
+package dns
+
+import (
+	"time"
+)
+
+// tsigStreamInterval is the RFC 2845 section 4.4 signing interval: every
+// 100th envelope, in addition to the first and last, must carry a TSIG, so
+// at most tsigStreamInterval-1 envelopes in a row may go without one.
+const tsigStreamInterval = 100
+
+// TsigVerifier checks a stream of TSIG-signed envelopes, such as the
+// responses of an AXFR or IXFR, against a single TsigProvider. Create one
+// with NewTsigVerifier per transfer, call AddEnvelope for each envelope in
+// order, and call Finish once the stream ends.
+type TsigVerifier struct {
+	provider   TsigProvider
+	requestMAC string
+
+	n             int    // envelopes seen so far
+	signed        int    // signed envelopes verified so far
+	unsignedCount int    // consecutive unsigned envelopes since the last signed one
+	unsigned      []byte // raw wire data of envelopes since the last verified one
+}
+
+// NewTsigVerifier returns a TsigVerifier that checks envelopes against
+// provider. requestMAC is the MAC of the query that triggered the
+// transfer, as returned by TsigGenerate/TsigGenerateProvider.
+func NewTsigVerifier(provider TsigProvider, requestMAC string) *TsigVerifier {
+	return &TsigVerifier{provider: provider, requestMAC: requestMAC}
+}
+
+// AddEnvelope checks one envelope of the stream, in order. msg is the raw
+// wire-format message, with or without a trailing TSIG RR.
+func (v *TsigVerifier) AddEnvelope(msg []byte) error {
+	v.n++
+
+	stripped, tsig, err := stripTsig(msg)
+	if err != nil {
+		if err != ErrNoSig {
+			return err
+		}
+		// This envelope has no TSIG of its own. That's only allowed
+		// strictly between the first and last envelope, and for at most
+		// tsigStreamInterval-1 in a row: once unsignedCount would reach
+		// tsigStreamInterval this envelope must have been signed instead.
+		if v.n == 1 {
+			return ErrNoSig
+		}
+		if v.unsignedCount >= tsigStreamInterval-1 {
+			return ErrNoSig
+		}
+		v.unsignedCount++
+		v.unsigned = append(v.unsigned, msg...)
+		return nil
+	}
+
+	buf := v.streamBuffer(stripped, tsig)
+	if err := v.provider.Verify(buf, tsig); err != nil {
+		return err
+	}
+
+	ti := uint64(time.Now().Unix()) - tsig.TimeSigned
+	if uint64(tsig.Fudge) < ti {
+		return ErrTime
+	}
+
+	v.requestMAC = tsig.MAC
+	v.unsigned = v.unsigned[:0]
+	v.unsignedCount = 0
+	v.signed++
+	return nil
+}
+
+// Finish must be called once the last envelope has been passed to
+// AddEnvelope. RFC 2845 section 4.4 requires the last envelope of a stream
+// to carry a TSIG, so Finish reports an error if the stream ended on an
+// unsigned one.
+func (v *TsigVerifier) Finish() error {
+	if len(v.unsigned) != 0 {
+		return ErrNoSig
+	}
+	return nil
+}
+
+// streamBuffer builds the data covered by the MAC on a signed envelope: the
+// buffered envelopes since the last one, and the signed envelope itself
+// with its TSIG stripped, fed through tsigBuffer as a single "message" so
+// the request MAC and TSIG variables are laid out exactly as
+// TsigGenerate/TsigVerify expect.
+//
+// Per RFC 2845 section 4.4, only the first signed envelope in the stream
+// is digested against the full TSIG variables (name/class/ttl/algorithm/
+// error/other); every signed envelope after that is digested against the
+// timers only (TimeSigned/Fudge) - tsigBuffer's timersOnly path.
+func (v *TsigVerifier) streamBuffer(stripped []byte, tsig *RR_TSIG) []byte {
+	msgbuf := append(append([]byte(nil), v.unsigned...), stripped...)
+	return tsigBuffer(msgbuf, tsig, v.requestMAC, v.signed != 0)
+}
@@ -0,0 +1,152 @@
+package dns
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+const xfrTestSecret = "so6ZGir4GPAqINNh9U5c3A=="
+
+// xfrEnvelope builds one envelope of a multi-message TSIG stream for
+// testing TsigVerifier. unsignedPrefix is the raw wire bytes of any
+// unsigned envelopes buffered since the last signed one (nil if none);
+// timersOnly selects whether the MAC covers the full TSIG variables or
+// just the timers, per RFC 2845 section 4.4. The actual digest is built
+// with tsigBuffer, the same unexported helper TsigVerifier.streamBuffer
+// calls, rather than reimplementing its wire format here; these tests
+// therefore check the stream handling around tsigBuffer, not the wire
+// format tsigBuffer itself produces.
+func xfrEnvelope(t *testing.T, provider TsigProvider, keyname, requestMAC string, unsignedPrefix []byte, timersOnly, signed, soa bool) ([]byte, string) {
+	t.Helper()
+
+	m := new(Msg)
+	m.SetQuestion("example.com.", TypeAXFR)
+	if soa {
+		m.Answer = []RR{&RR_SOA{Hdr: RR_Header{Name: "example.com.", Rrtype: TypeSOA, Class: ClassINET}}}
+	}
+
+	if !signed {
+		mbuf, ok := m.Pack()
+		if !ok {
+			t.Fatalf("Pack: failed")
+		}
+		return mbuf, requestMAC
+	}
+
+	rr := &RR_TSIG{
+		Hdr:        RR_Header{Name: keyname, Rrtype: TypeTSIG, Class: ClassANY},
+		Algorithm:  HmacSHA256,
+		Fudge:      300,
+		TimeSigned: uint64(time.Now().Unix()),
+	}
+	mbuf, ok := m.Pack()
+	if !ok {
+		t.Fatalf("Pack: failed")
+	}
+
+	msgbuf := append(append([]byte(nil), unsignedPrefix...), mbuf...)
+	buf := tsigBuffer(msgbuf, rr, requestMAC, timersOnly)
+	mac, err := provider.Generate(buf, rr)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	rr.MAC = hex.EncodeToString(mac)
+	rr.MACSize = uint16(len(rr.MAC) / 2)
+	rr.OrigId = m.MsgHdr.Id
+
+	sbuf := make([]byte, rr.Len())
+	off, ok := packRR(rr, sbuf, 0, nil, false)
+	if !ok {
+		t.Fatalf("packRR: failed")
+	}
+	mbuf = append(mbuf, sbuf[:off]...)
+	RawSetExtraLen(mbuf, 1)
+	return mbuf, rr.MAC
+}
+
+// TestTsigVerifierMultiEnvelope exercises the BIND-style pattern this
+// package is meant to accept: a signed envelope, a run of unsigned
+// envelopes, then another signed envelope whose MAC covers only the TSIG
+// timers, not the full TSIG variables, plus the unsigned envelopes folded
+// in since the last signed one.
+func TestTsigVerifierMultiEnvelope(t *testing.T) {
+	provider := hmacProvider(xfrTestSecret)
+	v := NewTsigVerifier(provider, "")
+
+	env1, mac1 := xfrEnvelope(t, provider, "axfr.", "", nil, false, true, false)
+	if err := v.AddEnvelope(env1); err != nil {
+		t.Fatalf("envelope 1 (first, signed): %v", err)
+	}
+
+	env2, _ := xfrEnvelope(t, provider, "axfr.", "", nil, false, false, false)
+	if err := v.AddEnvelope(env2); err != nil {
+		t.Fatalf("envelope 2 (unsigned): %v", err)
+	}
+
+	env3, _ := xfrEnvelope(t, provider, "axfr.", mac1, env2, true, true, true)
+	if err := v.AddEnvelope(env3); err != nil {
+		t.Fatalf("envelope 3 (signed, timers only): %v", err)
+	}
+
+	if err := v.Finish(); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+}
+
+// TestTsigVerifierRequiresFirstSigned checks that a stream cannot open on
+// an unsigned envelope.
+func TestTsigVerifierRequiresFirstSigned(t *testing.T) {
+	provider := hmacProvider(xfrTestSecret)
+	v := NewTsigVerifier(provider, "")
+
+	env, _ := xfrEnvelope(t, provider, "axfr.", "", nil, false, false, false)
+	if err := v.AddEnvelope(env); err == nil {
+		t.Fatalf("expected an error for an unsigned first envelope, got nil")
+	}
+}
+
+// TestTsigVerifierRequiresLastSigned checks that Finish rejects a stream
+// that ends on an unsigned envelope.
+func TestTsigVerifierRequiresLastSigned(t *testing.T) {
+	provider := hmacProvider(xfrTestSecret)
+	v := NewTsigVerifier(provider, "")
+
+	env1, _ := xfrEnvelope(t, provider, "axfr.", "", nil, false, true, false)
+	if err := v.AddEnvelope(env1); err != nil {
+		t.Fatalf("envelope 1: %v", err)
+	}
+	env2, _ := xfrEnvelope(t, provider, "axfr.", "", nil, false, false, true)
+	if err := v.AddEnvelope(env2); err != nil {
+		t.Fatalf("envelope 2 (unsigned): %v", err)
+	}
+
+	if err := v.Finish(); err == nil {
+		t.Fatalf("expected Finish to reject a stream ending unsigned, got nil")
+	}
+}
+
+// TestTsigVerifierTooManyUnsigned checks that more than
+// tsigStreamInterval-1 consecutive unsigned envelopes is rejected -
+// guarding against a stream that never carries a required periodic TSIG.
+func TestTsigVerifierTooManyUnsigned(t *testing.T) {
+	provider := hmacProvider(xfrTestSecret)
+	v := NewTsigVerifier(provider, "")
+
+	env1, _ := xfrEnvelope(t, provider, "axfr.", "", nil, false, true, false)
+	if err := v.AddEnvelope(env1); err != nil {
+		t.Fatalf("envelope 1: %v", err)
+	}
+
+	var lastErr error
+	for i := 0; i < tsigStreamInterval; i++ {
+		env, _ := xfrEnvelope(t, provider, "axfr.", "", nil, false, false, false)
+		lastErr = v.AddEnvelope(env)
+		if lastErr != nil {
+			break
+		}
+	}
+	if lastErr == nil {
+		t.Fatalf("expected an error within %d consecutive unsigned envelopes, got nil", tsigStreamInterval)
+	}
+}